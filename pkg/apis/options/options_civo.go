@@ -0,0 +1,24 @@
+package options
+
+import "time"
+
+// CivoOptions contains options for the Civo provider.
+type CivoOptions struct {
+	// Account is the account_id to restrict access to.
+	Account string `flag:"civo-account" cfg:"civo_account"`
+	// Permissions is the list of permission codes (e.g. "compute.updater",
+	// "compute.*", "*.*") a user must hold in Account to be let through.
+	Permissions []string `flag:"civo-permission" cfg:"civo_permissions"`
+	// PermissionsURL is the url to verify the user permissions in Account.
+	PermissionsURL string `flag:"civo-permissions-url" cfg:"civo_permissions_url"`
+	// PermissionsMode is "any" (default: at least one of Permissions must
+	// match) or "all" (every entry in Permissions must match).
+	PermissionsMode string `flag:"civo-permissions-mode" cfg:"civo_permissions_mode"`
+	// PermissionsCacheTTL is how long a fetched permission set is served from
+	// cache before a background refresh is triggered. Defaults to 30s.
+	PermissionsCacheTTL time.Duration `flag:"civo-permissions-cache-ttl" cfg:"civo_permissions_cache_ttl"`
+	// RefreshInterval is how often RefreshSessionIfNeeded re-checks the
+	// user's permissions against Account. When zero, the provider's
+	// RefreshPeriod is used instead; with both zero the check is skipped.
+	RefreshInterval time.Duration `flag:"civo-refresh-interval" cfg:"civo_refresh_interval"`
+}
@@ -0,0 +1,94 @@
+package authz
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Options configures an Authorizer: which permission codes are required
+// (optionally using "service.action" wildcards), whether one or all of them
+// must match, and how long fetched permissions are cached before a
+// background refresh. Any provider that wants to gate access on an external
+// permissions endpoint can embed Options in its own options struct and hand
+// it to New alongside a PermissionSource, instead of reimplementing the
+// fetch/match/cache flow CivoProvider uses.
+type Options struct {
+	Permissions     []string
+	PermissionsMode string
+	CacheTTL        time.Duration
+	RefreshInterval time.Duration
+}
+
+// Authorizer bundles a PermissionSource, a Matcher and a Cache into the
+// fetch-then-match-then-cache flow shared by every permissions-based
+// provider: CivoProvider today, and - by supplying their own PermissionSource -
+// GitHub teams, GitLab groups, Keystone roles or OpenShift SARs tomorrow.
+type Authorizer struct {
+	source          PermissionSource
+	matcher         *Matcher
+	cache           *Cache
+	refreshInterval time.Duration
+}
+
+// New builds an Authorizer over source using opts.
+func New(source PermissionSource, opts Options) *Authorizer {
+	mode := ModeAny
+	if strings.EqualFold(opts.PermissionsMode, string(ModeAll)) {
+		mode = ModeAll
+	}
+
+	return &Authorizer{
+		source:          source,
+		matcher:         NewMatcher(opts.Permissions, mode),
+		cache:           NewCache(source, opts.CacheTTL),
+		refreshInterval: opts.RefreshInterval,
+	}
+}
+
+// RefreshInterval is the configured Options.RefreshInterval.
+func (a *Authorizer) RefreshInterval() time.Duration {
+	return a.refreshInterval
+}
+
+// Enrich fetches permissions for key directly from source, bypassing the
+// cache, and seeds the cache with the result so a later Allowed can serve it.
+// Callers should use this once per session, right after redeeming it.
+func (a *Authorizer) Enrich(ctx context.Context, key, accessToken string) ([]Permission, error) {
+	permissions, err := a.source.Fetch(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	a.cache.Set(key, permissions)
+	return permissions, nil
+}
+
+// Seed stores permissions for key directly, without calling source.Fetch.
+// Useful for tests, or for providers that already have a fresh permission set
+// in hand (e.g. returned alongside the token response) and just want it
+// cached for the next Allowed call.
+func (a *Authorizer) Seed(key string, permissions []Permission) {
+	a.cache.Set(key, permissions)
+}
+
+// Allowed serves permissions for key out of the cache (fetching on a miss)
+// and reports whether they satisfy the configured Matcher.
+func (a *Authorizer) Allowed(ctx context.Context, key, accessToken string) ([]Permission, bool, error) {
+	permissions, err := a.cache.Get(ctx, key, accessToken)
+	if err != nil {
+		return nil, false, err
+	}
+	return permissions, a.matcher.Allowed(permissions), nil
+}
+
+// MatchAllowed reports whether permissions satisfies the configured Matcher,
+// without touching the cache.
+func (a *Authorizer) MatchAllowed(permissions []Permission) bool {
+	return a.matcher.Allowed(permissions)
+}
+
+// Evict drops any cached permissions for key, e.g. once a session is no
+// longer valid.
+func (a *Authorizer) Evict(key string) {
+	a.cache.Delete(key)
+}
@@ -0,0 +1,31 @@
+// Package authz provides a reusable permissions-based authorization building
+// block. An external PermissionSource fetches the permissions held by a
+// user; a Matcher decides whether that set satisfies a configured list of
+// required permissions; Cache wraps a PermissionSource with a small
+// stale-while-revalidate TTL cache. CivoProvider is the first adapter over
+// this package; GitHub teams, GitLab groups, Keystone roles and OpenShift
+// SARs follow the same fetch-then-match shape and can plug in their own
+// PermissionSource without duplicating the caching or matching logic.
+//
+// CivoProvider is the only provider that uses this package; no other provider
+// (the OIDC provider included) has options to opt into an external
+// permissions endpoint.
+package authz
+
+import "context"
+
+// Permission is a single permission granted to a user, as returned by a
+// PermissionSource. Code is expected to be of the form "service.action"
+// (e.g. "compute.updater") so that Matcher can apply wildcards to it.
+type Permission struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// PermissionSource fetches the permissions a user holds, given their access
+// token. Implementations talk to whatever external system backs them (a
+// Civo account, a GitHub team, a Keystone role assignment, ...).
+type PermissionSource interface {
+	Fetch(ctx context.Context, accessToken string) ([]Permission, error)
+}
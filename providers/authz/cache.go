@@ -0,0 +1,146 @@
+package authz
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCacheTTL is used by NewCache when no TTL is configured.
+const DefaultCacheTTL = 30 * time.Second
+
+// cacheEntry is a single cached lookup.
+type cacheEntry struct {
+	permissions []Permission
+	fetchedAt   time.Time
+	refreshing  int32 // accessed atomically; guards against duplicate background refreshes
+}
+
+func (e *cacheEntry) expired(ttl time.Duration) bool {
+	return time.Since(e.fetchedAt) > ttl
+}
+
+// inflightFetch tracks a single in-flight call to PermissionSource.Fetch for a
+// given cache key, so concurrent cold-cache misses for the same key are
+// coalesced into one request instead of each dialing the external endpoint.
+type inflightFetch struct {
+	done        chan struct{}
+	permissions []Permission
+	err         error
+}
+
+// Cache is a small stale-while-revalidate TTL cache in front of a
+// PermissionSource, keyed by an arbitrary caller-chosen string (typically
+// "<account>/<user>"), so bursts of requests for the same principal don't
+// each trigger a round trip to the external permissions endpoint.
+type Cache struct {
+	ttl      time.Duration
+	source   PermissionSource
+	store    sync.Map // map[string]*cacheEntry
+	inflight sync.Map // map[string]*inflightFetch, de-dupes concurrent cold misses
+	// epochs is map[string]*int64, bumped by Delete to fence stale background
+	// refreshes (see setIfCurrent). Unlike store, an epoch entry is never
+	// removed once created: a refresh can still be in flight for a key after
+	// its store entry is gone, so there's no safe point at which we know no
+	// in-flight refresh still holds a reference to it. In practice this is
+	// bounded by the number of distinct keys (account/user pairs) ever seen
+	// by the process, the same cardinality store itself grows with, so it's
+	// an acceptable tradeoff rather than an unbounded leak.
+	epochs sync.Map
+}
+
+// NewCache wraps source with a TTL cache. A non-positive ttl falls back to
+// DefaultCacheTTL.
+func NewCache(source PermissionSource, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &Cache{ttl: ttl, source: source}
+}
+
+// Get serves permissions for key out of the cache, fetching synchronously on
+// a miss. On a stale hit it still returns the cached value but kicks off a
+// single background refresh via source.Fetch (stale-while-revalidate).
+// Empty or error responses from source are never cached.
+func (c *Cache) Get(ctx context.Context, key, accessToken string) ([]Permission, error) {
+	entry, ok := c.load(key)
+	if !ok {
+		return c.fetchOnce(ctx, key, accessToken)
+	}
+
+	if entry.expired(c.ttl) && atomic.CompareAndSwapInt32(&entry.refreshing, 0, 1) {
+		epoch := atomic.LoadInt64(c.epochPtr(key))
+		go func() {
+			defer atomic.StoreInt32(&entry.refreshing, 0)
+			permissions, err := c.source.Fetch(context.Background(), accessToken)
+			if err != nil {
+				return
+			}
+			c.setIfCurrent(key, permissions, epoch)
+		}()
+	}
+
+	return entry.permissions, nil
+}
+
+// fetchOnce fetches permissions for key, coalescing concurrent callers for the
+// same key into a single call to source.Fetch.
+func (c *Cache) fetchOnce(ctx context.Context, key, accessToken string) ([]Permission, error) {
+	fetch := &inflightFetch{done: make(chan struct{})}
+	actual, loaded := c.inflight.LoadOrStore(key, fetch)
+	fetch = actual.(*inflightFetch)
+	if loaded {
+		<-fetch.done
+		return fetch.permissions, fetch.err
+	}
+
+	fetch.permissions, fetch.err = c.source.Fetch(ctx, accessToken)
+	if fetch.err == nil {
+		c.Set(key, fetch.permissions)
+	}
+	c.inflight.Delete(key)
+	close(fetch.done)
+
+	return fetch.permissions, fetch.err
+}
+
+func (c *Cache) load(key string) (*cacheEntry, bool) {
+	v, ok := c.store.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*cacheEntry), true
+}
+
+// Set stores permissions for key. Empty permission sets are never cached.
+func (c *Cache) Set(key string, permissions []Permission) {
+	if len(permissions) == 0 {
+		return
+	}
+	c.store.Store(key, &cacheEntry{permissions: permissions, fetchedAt: time.Now()})
+}
+
+// setIfCurrent stores permissions for key unless Delete has fenced key past
+// epoch since the caller read it, i.e. unless a background refresh started
+// before an eviction is about to resurrect the entry it was meant to drop.
+func (c *Cache) setIfCurrent(key string, permissions []Permission, epoch int64) {
+	if atomic.LoadInt64(c.epochPtr(key)) != epoch {
+		return
+	}
+	c.Set(key, permissions)
+}
+
+// epochPtr returns the fencing counter for key, creating it on first use.
+func (c *Cache) epochPtr(key string) *int64 {
+	v, _ := c.epochs.LoadOrStore(key, new(int64))
+	return v.(*int64)
+}
+
+// Delete evicts key, e.g. after ValidateSession finds the backing session
+// invalid. It also bumps key's fencing epoch, so a background refresh that
+// started before this call can't resurrect the entry this just dropped.
+func (c *Cache) Delete(key string) {
+	atomic.AddInt64(c.epochPtr(key), 1)
+	c.store.Delete(key)
+}
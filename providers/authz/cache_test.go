@@ -0,0 +1,125 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingSource counts Fetch calls and optionally sleeps before replying, so
+// tests can hold the cache's background-refresh guard open long enough to
+// observe it.
+type countingSource struct {
+	calls int32
+	delay time.Duration
+	err   error
+}
+
+func (s *countingSource) Fetch(ctx context.Context, accessToken string) ([]Permission, error) {
+	n := atomic.AddInt32(&s.calls, 1)
+	if s.delay > 0 {
+		time.Sleep(s.delay)
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []Permission{{Code: fmt.Sprintf("compute.%d", n)}}, nil
+}
+
+func TestCacheGetFetchesOnMiss(t *testing.T) {
+	source := &countingSource{}
+	cache := NewCache(source, time.Hour)
+
+	permissions, err := cache.Get(context.Background(), "account/user", "token")
+	assert.NoError(t, err)
+	assert.Equal(t, []Permission{{Code: "compute.1"}}, permissions)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&source.calls))
+}
+
+func TestCacheGetServesStaleValueAndRefreshesOnceInBackground(t *testing.T) {
+	source := &countingSource{delay: 50 * time.Millisecond}
+	cache := NewCache(source, 10*time.Millisecond)
+
+	first, err := cache.Get(context.Background(), "account/user", "token")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&source.calls))
+
+	time.Sleep(20 * time.Millisecond) // let the entry go stale
+
+	// Several reads while the entry is stale should all get served the cached
+	// value immediately, and only the first should kick off a refresh: the
+	// others observe entry.refreshing already set and skip spawning one.
+	for i := 0; i < 5; i++ {
+		stale, err := cache.Get(context.Background(), "account/user", "token")
+		assert.NoError(t, err)
+		assert.Equal(t, first, stale)
+	}
+
+	// The refresh goroutine spawned on the loop's first iteration may not have
+	// reached source.Fetch yet, so poll instead of asserting immediately.
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&source.calls) == 2
+	}, time.Second, time.Millisecond, "expected exactly one background refresh to be kicked off")
+
+	assert.Eventually(t, func() bool {
+		refreshed, err := cache.Get(context.Background(), "account/user", "token")
+		return err == nil && !assert.ObjectsAreEqual(first, refreshed)
+	}, time.Second, time.Millisecond, "expected the background refresh to land")
+}
+
+func TestCacheSetNeverCachesEmptyPermissions(t *testing.T) {
+	cache := NewCache(&countingSource{}, time.Hour)
+
+	cache.Set("account/user", nil)
+	_, ok := cache.load("account/user")
+	assert.False(t, ok)
+}
+
+func TestCacheGetDoesNotCacheFetchErrors(t *testing.T) {
+	cache := NewCache(&countingSource{err: errors.New("boom")}, time.Hour)
+
+	_, err := cache.Get(context.Background(), "account/user", "token")
+	assert.Error(t, err)
+
+	_, ok := cache.load("account/user")
+	assert.False(t, ok)
+}
+
+func TestCacheDelete(t *testing.T) {
+	cache := NewCache(&countingSource{}, time.Hour)
+
+	_, err := cache.Get(context.Background(), "account/user", "token")
+	assert.NoError(t, err)
+
+	cache.Delete("account/user")
+	_, ok := cache.load("account/user")
+	assert.False(t, ok)
+}
+
+func TestCacheDeleteDuringBackgroundRefreshIsNotResurrected(t *testing.T) {
+	source := &countingSource{delay: 50 * time.Millisecond}
+	cache := NewCache(source, 10*time.Millisecond)
+
+	_, err := cache.Get(context.Background(), "account/user", "token")
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond) // let the entry go stale
+
+	// Kicks off a background refresh that won't land for ~50ms.
+	_, err = cache.Get(context.Background(), "account/user", "token")
+	assert.NoError(t, err)
+
+	// Evict before the in-flight refresh above has a chance to Set.
+	cache.Delete("account/user")
+
+	// Give the background refresh time to land, then make sure it didn't
+	// resurrect the entry this just evicted.
+	time.Sleep(100 * time.Millisecond)
+	_, ok := cache.load("account/user")
+	assert.False(t, ok, "background refresh started before Delete must not repopulate the cache")
+}
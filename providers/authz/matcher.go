@@ -0,0 +1,94 @@
+package authz
+
+import "strings"
+
+// MatchMode controls whether Matcher.Allowed requires a single matching
+// pattern (ModeAny) or all of them (ModeAll).
+type MatchMode string
+
+const (
+	ModeAny MatchMode = "any"
+	ModeAll MatchMode = "all"
+)
+
+// wildcard is the segment that matches any service or action in a pattern.
+const wildcard = "*"
+
+// pattern is a required permission compiled into its service/action parts.
+type pattern struct {
+	service string
+	action  string
+}
+
+// parsePattern compiles a "service.action" string such as "*.*", "compute.*"
+// or "compute.updater" into a pattern.
+func parsePattern(code string) pattern {
+	service, action, found := strings.Cut(code, ".")
+	if !found {
+		// no "." present: treat the whole code as the service, matching any action
+		return pattern{service: service, action: wildcard}
+	}
+	return pattern{service: service, action: action}
+}
+
+// matches reports whether perm.Code satisfies this pattern, honoring "*"
+// wildcards in either the service or the action segment.
+func (pat pattern) matches(perm Permission) bool {
+	service, action, found := strings.Cut(perm.Code, ".")
+	if !found {
+		service, action = perm.Code, ""
+	}
+	if pat.service != wildcard && pat.service != service {
+		return false
+	}
+	if pat.action != wildcard && pat.action != action {
+		return false
+	}
+	return true
+}
+
+// Matcher decides whether a fetched set of Permissions satisfies a
+// configured list of required permission codes, which may use
+// "service.action" wildcards such as "*.*" or "compute.*".
+type Matcher struct {
+	patterns []pattern
+	mode     MatchMode
+}
+
+// NewMatcher compiles required, a list of "service.action" permission codes,
+// once so Allowed stays allocation-free on the hot path. An unrecognized mode
+// falls back to ModeAny.
+func NewMatcher(required []string, mode MatchMode) *Matcher {
+	if mode != ModeAll {
+		mode = ModeAny
+	}
+	patterns := make([]pattern, 0, len(required))
+	for _, code := range required {
+		patterns = append(patterns, parsePattern(code))
+	}
+	return &Matcher{patterns: patterns, mode: mode}
+}
+
+// Allowed reports whether permissions satisfies the Matcher's required
+// patterns, per its MatchMode: ModeAny needs one match, ModeAll needs every
+// pattern to match at least one permission.
+func (m *Matcher) Allowed(permissions []Permission) bool {
+	if len(m.patterns) == 0 {
+		return false
+	}
+
+	matched := 0
+	for _, pat := range m.patterns {
+		for _, perm := range permissions {
+			if pat.matches(perm) {
+				matched++
+				if m.mode == ModeAny {
+					return true
+				}
+				break
+			}
+		}
+	}
+
+	return m.mode == ModeAll && matched == len(m.patterns)
+}
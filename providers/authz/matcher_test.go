@@ -0,0 +1,46 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatcherAllowed(t *testing.T) {
+	testCases := []struct {
+		name        string
+		required    []string
+		mode        MatchMode
+		permissions []Permission
+		allowed     bool
+	}{
+		{"exact match", []string{"compute.updater"}, ModeAny, []Permission{{Code: "compute.updater"}}, true},
+		{"no match", []string{"compute.updater"}, ModeAny, []Permission{{Code: "compute.reader"}}, false},
+		{"wildcard service", []string{"*.read"}, ModeAny, []Permission{{Code: "network.read"}}, true},
+		{"wildcard action", []string{"compute.*"}, ModeAny, []Permission{{Code: "compute.deleter"}}, true},
+		{"wildcard everything", []string{"*.*"}, ModeAny, []Permission{{Code: "anything.here"}}, true},
+		{"dot-less required code matches any action on that service", []string{"compute"}, ModeAny, []Permission{{Code: "compute.updater"}}, true},
+		{"dot-less required code does not match a different service", []string{"compute"}, ModeAny, []Permission{{Code: "network.read"}}, false},
+		{"dot-less permission code only matches an empty action", []string{"compute.updater"}, ModeAny, []Permission{{Code: "compute"}}, false},
+		{"no required permissions never allows", nil, ModeAny, []Permission{{Code: "*.*"}}, false},
+		{"empty permissions never allows", []string{"compute.updater"}, ModeAny, nil, false},
+		{"mode any: one of many matches", []string{"compute.*", "network.read"}, ModeAny, []Permission{{Code: "network.read"}}, true},
+		{"mode all: one of many matches", []string{"compute.*", "network.read"}, ModeAll, []Permission{{Code: "network.read"}}, false},
+		{"mode all: every pattern matches", []string{"compute.*", "network.read"}, ModeAll, []Permission{{Code: "compute.updater"}, {Code: "network.read"}}, true},
+		{"mode all: duplicate permissions satisfying the same pattern don't stand in for a missing one", []string{"compute.*", "network.read"}, ModeAll, []Permission{{Code: "compute.updater"}, {Code: "compute.reader"}}, false},
+		{"unrecognized mode falls back to any", []string{"compute.updater"}, MatchMode("bogus"), []Permission{{Code: "compute.updater"}}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMatcher(tc.required, tc.mode)
+			assert.Equal(t, tc.allowed, m.Allowed(tc.permissions))
+		})
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	assert.Equal(t, pattern{service: "compute", action: "updater"}, parsePattern("compute.updater"))
+	assert.Equal(t, pattern{service: "compute", action: wildcard}, parsePattern("compute"))
+	assert.Equal(t, pattern{service: wildcard, action: wildcard}, parsePattern("*.*"))
+}
@@ -2,24 +2,48 @@ package providers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/url"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/providers/authz"
 )
 
+// redactUserID replaces a user ID with a short, non-reversible fingerprint, so
+// debug logs can correlate requests to the same user without printing PII.
+func redactUserID(userID string) string {
+	if userID == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// Permission is the struct representing the Civo permission. It is an alias
+// of authz.Permission so existing callers referencing providers.Permission
+// keep working now that the fetch-and-match logic lives in authz.
+type Permission = authz.Permission
+
 // CivoProvider represents a Civo based Identity Provider
 type CivoProvider struct {
 	*ProviderData
 	// Account is the account_id to restrict access to
 	Account string
-	// PermissionsMap is the map of permissions to restrict access to
-	PermissionsMap map[string]struct{}
 	// PermissionsURL is the url to verify the user permissions in the specified account
 	PermissionsURL string
+
+	// authz fetches, caches and matches the user's permissions in Account; see
+	// providers/authz. CivoProvider is a thin adapter over it - any other
+	// provider can reuse the same flow by implementing authz.PermissionSource
+	// and building its own authz.Authorizer from authz.Options, without
+	// copying this fetch/match/cache logic.
+	authz *authz.Authorizer
 }
 
 var _ Provider = (*CivoProvider)(nil)
@@ -67,26 +91,24 @@ func NewCivoProvider(p *ProviderData, opts options.CivoOptions) *CivoProvider {
 	})
 	p.getAuthorizationHeaderFunc = makeOIDCHeader
 
-	// using a map to avoid nested cycle to every request. The key of the map is the permission string
-	requiredPermissionsMap := make(map[string]struct{}, 0)
-	for _, perm := range opts.Permissions {
-		requiredPermissionsMap[perm] = struct{}{}
+	if opts.RefreshInterval > 0 {
+		p.RefreshPeriod = opts.RefreshInterval
 	}
-	/*
-		    map[string] struct{}{
-				"*.*": struct{}{},
-				"compute.*": struct{}{},
-				"compute.updater": struct{}{},
-			}
-	*/
 
-	fmt.Printf("DEBUG -  Civo - Composed Permissions Map %+v\n", requiredPermissionsMap)
+	source := civoPermissionSource{permissionsURL: opts.PermissionsURL, account: opts.Account}
+
+	logger.Debugf("civo: required permissions %v (mode=%s)", opts.Permissions, opts.PermissionsMode)
 
 	return &CivoProvider{
 		ProviderData:   p,
-		Account:        opts.Account,           // owner of the namespace in which this pod lives (and the respective instance you would like to have access to)
-		PermissionsMap: requiredPermissionsMap, // static map of permissions initially passed as flags from the civo-operation-operator
-		PermissionsURL: opts.PermissionsURL,    // api permissions url to fetch if the user under this account has real-time permissions to perform a VNC access
+		Account:        opts.Account,        // owner of the namespace in which this pod lives (and the respective instance you would like to have access to)
+		PermissionsURL: opts.PermissionsURL, // api permissions url to fetch if the user under this account has real-time permissions to perform a VNC access
+		authz: authz.New(source, authz.Options{
+			Permissions:     opts.Permissions,
+			PermissionsMode: opts.PermissionsMode,
+			CacheTTL:        opts.PermissionsCacheTTL,
+			RefreshInterval: opts.RefreshInterval,
+		}),
 	}
 }
 
@@ -117,7 +139,50 @@ func (p *CivoProvider) GetEmailAddress(ctx context.Context, s *sessions.SessionS
 
 // ValidateSession validates the AccessToken
 func (p *CivoProvider) ValidateSession(ctx context.Context, s *sessions.SessionState) bool {
-	return validateToken(ctx, p, s.AccessToken, makeOIDCHeader(s.AccessToken))
+	if validateToken(ctx, p, s.AccessToken, makeOIDCHeader(s.AccessToken)) {
+		return true
+	}
+	p.authz.Evict(p.permissionsCacheKey(s.User))
+	return false
+}
+
+// RefreshSessionIfNeeded re-checks the user's Civo permissions every refresh
+// interval (options.CivoOptions.RefreshInterval, falling back to the
+// provider's RefreshPeriod) and forces re-authentication as soon as the
+// permission set no longer satisfies the configured permissions. Between
+// checks it relies on the authz cache, so this is cheap to call on every
+// request.
+func (p *CivoProvider) RefreshSessionIfNeeded(ctx context.Context, s *sessions.SessionState) (bool, error) {
+	if s == nil || s.AccessToken == "" {
+		return false, nil
+	}
+
+	interval := p.authz.RefreshInterval()
+	if period := p.Data().RefreshPeriod; period > 0 {
+		interval = period
+	}
+	if interval <= 0 {
+		return true, nil
+	}
+
+	key := p.permissionsCacheKey(s.User)
+	permissions, allowed, err := p.authz.Allowed(ctx, key, s.AccessToken)
+	if err != nil {
+		return false, err
+	}
+
+	if !allowed {
+		logger.Debugf("civo: user %s in account %s no longer has sufficient permissions (%d held)", redactUserID(s.User), p.Account, len(permissions))
+		p.authz.Evict(key)
+		return false, fmt.Errorf("user %s in account %s no longer has sufficient permissions", s.User, p.Account)
+	}
+
+	return true, nil
+}
+
+// permissionsCacheKey identifies a cached permission set by account_id/user_id.
+func (p *CivoProvider) permissionsCacheKey(userID string) string {
+	return p.Account + "/" + userID
 }
 
 // EnrichSession updates the User & Email after the initial Redeem
@@ -126,7 +191,7 @@ func (p *CivoProvider) EnrichSession(ctx context.Context, s *sessions.SessionSta
 		return errors.New("missing access token")
 	}
 
-	fmt.Printf("DEBUG - Civo: provider.ProfileURL %s\n", p.ProfileURL.String())
+	logger.Debugf("civo: fetching profile from %s", p.ProfileURL.String())
 
 	json, err := requests.New(p.ProfileURL.String()).
 		WithContext(ctx).
@@ -141,43 +206,50 @@ func (p *CivoProvider) EnrichSession(ctx context.Context, s *sessions.SessionSta
 	if err != nil {
 		return err
 	}
+	s.User = user
 
-	fmt.Printf("DEBUG - Civo: EnrichSession user %s\n", user)
+	logger.Debugf("civo: EnrichSession resolved user %s", redactUserID(user))
 
-	permissions, err := p.getUserPermissionsInAccount(ctx, s.AccessToken)
+	// EnrichSession always bypasses the cache and talks to the Civo API
+	// directly; the result seeds the cache so RefreshSessionIfNeeded can serve
+	// it on subsequent requests.
+	permissions, err := p.authz.Enrich(ctx, p.permissionsCacheKey(user), s.AccessToken)
 	if err != nil {
-		fmt.Printf("DEBUG - Civo: EnrichSession get user permissions retuned an error %s \n", err.Error())
+		logger.Debugf("civo: EnrichSession failed to fetch user permissions: %v", err)
 		return err
 	}
 
-	fmt.Printf("DEBUG - Civo: EnrichSession permissions %s \n", permissions)
+	logger.Debugf("civo: EnrichSession fetched %d permission(s) for user %s", len(permissions), redactUserID(user))
 
-	if !p.isUserAllowed(permissions) {
+	if !p.authz.MatchAllowed(permissions) {
 		return fmt.Errorf("user %s in account %s has no sufficient permissions", user, p.Account)
 	}
 
-	fmt.Printf("DEBUG - Civo: EnrichSession user %s is allowed to VNC into account %s \n", user, p.Account)
+	logger.Debugf("civo: user %s is allowed to VNC into account %s", redactUserID(user), p.Account)
 
 	s.Groups = append(s.Groups, p.Account) // FIXME: is that correct? What is this Groups meant for? Should it be the user instead
 
 	return nil
 }
 
-// Permission is the struct representing the Civo permission
-type Permission struct {
-	Code        string `json:"code"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+// civoPermissionSource adapts Civo's permissions endpoint to authz.PermissionSource.
+type civoPermissionSource struct {
+	permissionsURL string
+	account        string
 }
 
-// returns the set of permissions the user has in the given account (it's the sum of the permissions for each team_membership)
-// the information of the user is in the accessToken claims, meanwhile the account is taken from the configuration of the oauth-proxy
-func (p *CivoProvider) getUserPermissionsInAccount(ctx context.Context, accessToken string) (permissions []Permission, err error) {
-
-	// adding the account_id as query param for the request
-	endpoint := fmt.Sprintf("%s%saccount_id=%s", p.PermissionsURL, joinerChar(p.PermissionsURL), p.Account)
-	fmt.Printf("DEBUG - Civo: get permissions endpoint %s \n", endpoint)
+// Fetch returns the set of permissions the user has in the configured account
+// (it's the sum of the permissions for each team_membership). The information
+// of the user is in the accessToken claims, meanwhile the account is taken
+// from the configuration of the oauth-proxy.
+func (s civoPermissionSource) Fetch(ctx context.Context, accessToken string) ([]authz.Permission, error) {
+	endpoint, err := s.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("civo: fetching permissions for account %s", s.account)
 
+	var permissions []authz.Permission
 	if err := requests.New(endpoint).
 		WithContext(ctx).
 		WithHeaders(makeOIDCHeader(accessToken)).
@@ -189,20 +261,18 @@ func (p *CivoProvider) getUserPermissionsInAccount(ctx context.Context, accessTo
 	return permissions, nil
 }
 
-func joinerChar(url string) string {
-	if hasQueryParams(url) {
-		return "&"
+// endpoint adds account_id to permissionsURL via net/url, rather than string
+// concatenation, so it keeps working if permissionsURL already carries query
+// parameters, escaped characters or a fragment.
+func (s civoPermissionSource) endpoint() (string, error) {
+	u, err := url.Parse(s.permissionsURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid civo permissions url %q: %w", s.permissionsURL, err)
 	}
-	return "?"
-}
 
-// returns true if the current session returns a set of permissions having a match with the requiredPermissions
-func (p *CivoProvider) isUserAllowed(userPermissions []Permission) bool {
-	for _, perm := range userPermissions {
-		if _, found := p.PermissionsMap[perm.Code]; found {
-			return true
-		}
-	}
-	fmt.Printf("DEBUG - Civo - isUserAllowed retuned false. User permissions %+v, Required one of these permissions %+v \n", userPermissions, p.PermissionsMap)
-	return false
+	q := u.Query()
+	q.Set("account_id", s.account)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
 }
@@ -5,7 +5,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/sessions"
@@ -133,3 +136,146 @@ func TestCivoProviderGetEmailAddressEmailNotPresentInPayload(t *testing.T) {
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, "", email)
 }
+
+func TestCivoProviderRefreshSessionIfNeededAllowed(t *testing.T) {
+	var fetches int32
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[{"code":"compute.updater"}]`))
+	}))
+	defer b.Close()
+
+	p := NewCivoProvider(&ProviderData{}, options.CivoOptions{
+		Account:         "account-1",
+		Permissions:     []string{"compute.updater"},
+		PermissionsURL:  b.URL,
+		RefreshInterval: time.Hour,
+	})
+	key := p.permissionsCacheKey("user-1")
+	p.authz.Seed(key, []Permission{{Code: "compute.updater"}})
+
+	session := &sessions.SessionState{AccessToken: "token", User: "user-1"}
+	ok, err := p.RefreshSessionIfNeeded(context.Background(), session)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Still cached, so this shouldn't have to reach the Civo API.
+	permissions, _, err := p.authz.Allowed(context.Background(), key, "token")
+	assert.NoError(t, err)
+	assert.Equal(t, []Permission{{Code: "compute.updater"}}, permissions)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&fetches))
+}
+
+func TestCivoProviderRefreshSessionIfNeededDenied(t *testing.T) {
+	var fetches int32
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[{"code":"compute.reader"}]`))
+	}))
+	defer b.Close()
+
+	p := NewCivoProvider(&ProviderData{}, options.CivoOptions{
+		Account:         "account-1",
+		Permissions:     []string{"compute.updater"},
+		PermissionsURL:  b.URL,
+		RefreshInterval: time.Hour,
+	})
+	key := p.permissionsCacheKey("user-1")
+	p.authz.Seed(key, []Permission{{Code: "compute.reader"}})
+
+	session := &sessions.SessionState{AccessToken: "token", User: "user-1"}
+	ok, err := p.RefreshSessionIfNeeded(context.Background(), session)
+	assert.Error(t, err)
+	assert.False(t, ok)
+
+	// Denial evicts the entry, so this has to reach the Civo API again.
+	_, _, err = p.authz.Allowed(context.Background(), key, "token")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&fetches))
+}
+
+func TestCivoProviderCachedPermissionsCoalescesConcurrentColdMisses(t *testing.T) {
+	var requests int32
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[{"code":"compute.updater"}]`))
+	}))
+	defer b.Close()
+
+	p := NewCivoProvider(&ProviderData{}, options.CivoOptions{
+		Account:        "account-1",
+		Permissions:    []string{"compute.updater"},
+		PermissionsURL: b.URL,
+	})
+	key := p.permissionsCacheKey("user-1")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			permissions, _, err := p.authz.Allowed(context.Background(), key, "token")
+			assert.NoError(t, err)
+			assert.Equal(t, []Permission{{Code: "compute.updater"}}, permissions)
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requests), "concurrent cold misses for the same user should be coalesced into a single fetch")
+}
+
+func TestCivoProviderIsUserAllowedWildcards(t *testing.T) {
+	testCases := []struct {
+		name        string
+		permissions []string
+		mode        string
+		userPerms   []Permission
+		allowed     bool
+	}{
+		{"exact match", []string{"compute.updater"}, "", []Permission{{Code: "compute.updater"}}, true},
+		{"no match", []string{"compute.updater"}, "", []Permission{{Code: "compute.reader"}}, false},
+		{"wildcard service", []string{"*.read"}, "", []Permission{{Code: "network.read"}}, true},
+		{"wildcard action", []string{"compute.*"}, "", []Permission{{Code: "compute.deleter"}}, true},
+		{"wildcard everything", []string{"*.*"}, "", []Permission{{Code: "anything.here"}}, true},
+		{"mode any: one of many matches", []string{"compute.*", "network.read"}, "any", []Permission{{Code: "network.read"}}, true},
+		{"mode all: one of many matches", []string{"compute.*", "network.read"}, "all", []Permission{{Code: "network.read"}}, false},
+		{"mode all: every pattern matches", []string{"compute.*", "network.read"}, "all", []Permission{{Code: "compute.updater"}, {Code: "network.read"}}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewCivoProvider(&ProviderData{}, options.CivoOptions{
+				Permissions:     tc.permissions,
+				PermissionsMode: tc.mode,
+			})
+			assert.Equal(t, tc.allowed, p.authz.MatchAllowed(tc.userPerms))
+		})
+	}
+}
+
+func TestCivoProviderValidateSessionEvictsPermissionsCacheOnFailure(t *testing.T) {
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer b.Close()
+
+	bURL, _ := url.Parse(b.URL)
+	p := testCivoProvider(bURL.Host)
+	updateURL(p.Data().ValidateURL, bURL.Host)
+
+	key := p.permissionsCacheKey("user-1")
+	p.authz.Seed(key, []Permission{{Code: "compute.updater"}})
+
+	session := &sessions.SessionState{AccessToken: "token", User: "user-1"}
+	assert.False(t, p.ValidateSession(context.Background(), session))
+
+	// The cache entry should have been evicted: a subsequent Allowed call has
+	// nothing to serve and falls through to a live fetch, which fails here
+	// because PermissionsURL isn't a real permissions endpoint in this test.
+	_, _, err := p.authz.Allowed(context.Background(), key, "token")
+	assert.Error(t, err)
+}